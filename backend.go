@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Backend executes the configured action for a dispatched work package. Implementations must not
+// block past ctx being done for longer than is needed to shut down cleanly.
+type Backend interface {
+	Handle(ctx context.Context, workPackage []workItem) error
+}
+
+// actionConfig is the YAML shape of a watched dir's `action` section. Which fields are required
+// depends on Type; see newBackend.
+type actionConfig struct {
+	Type        string
+	Directory   string
+	Destination string
+	SSHOptions  string `yaml:"ssh options"`
+	URL         string
+}
+
+// newBackend builds the Backend configured for one watched dir. An empty Type defaults to
+// "script" with scriptsDirFallback as its directory, which is the tool's original, script-only
+// behaviour.
+func newBackend(configurationFilePath, root string, action actionConfig, scriptsDirFallback string) Backend {
+	switch action.Type {
+	case "", "script":
+		scriptsDir := action.Directory
+		if scriptsDir == "" {
+			scriptsDir = scriptsDirFallback
+		}
+		return &scriptBackend{scriptsDir: scriptsDir}
+	case "rsync":
+		if action.Destination == "" {
+			logger.Panicf("Invalid configuration file %v: Action \"rsync\" requires a destination", configurationFilePath)
+		}
+		return &rsyncBackend{destination: action.Destination, sshOptions: action.SSHOptions}
+	case "copy":
+		if action.Destination == "" {
+			logger.Panicf("Invalid configuration file %v: Action \"copy\" requires a destination", configurationFilePath)
+		}
+		return &copyBackend{root: root, destination: action.Destination}
+	case "webhook":
+		if action.URL == "" {
+			logger.Panicf("Invalid configuration file %v: Action \"webhook\" requires a url", configurationFilePath)
+		}
+		return &webhookBackend{url: action.URL, client: &http.Client{Timeout: 30 * time.Second}}
+	default:
+		logger.Panicf("Invalid configuration file %v: Unknown action type %v", configurationFilePath, action.Type)
+		return nil
+	}
+}
+
+// scriptBackend is the original backend: it runs one of three external scripts (copy, delete,
+// bulk_sync) shipped alongside the binary in scriptsDir.
+type scriptBackend struct {
+	scriptsDir string
+}
+
+func (b *scriptBackend) Handle(ctx context.Context, workPackage []workItem) error {
+	var cmd *exec.Cmd
+	if len(workPackage) > 1 {
+		paths := make([]string, 0, len(workPackage))
+		for _, item := range workPackage {
+			paths = append(paths, item.path)
+		}
+		cmd = exec.Command(filepath.Join(b.scriptsDir, "bulk_sync"), longestPrefix(paths))
+	} else {
+		item := workPackage[0]
+		if item.eventType == deleted {
+			cmd = exec.Command(filepath.Join(b.scriptsDir, "delete"), item.path)
+		} else if item.nodeType == file {
+			cmd = exec.Command(filepath.Join(b.scriptsDir, "copy"), item.path)
+		} else {
+			cmd = exec.Command(filepath.Join(b.scriptsDir, "bulk_sync"), item.path)
+		}
+	}
+	logger.Println("Start external command", cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start external command: %w", err)
+	}
+	return waitOrStop(ctx, cmd, syscall.SIGTERM, 100*time.Millisecond)
+}
+
+// rsyncBackend ships modified paths to destination (an rsync target, e.g. "user@host:/path") over
+// SSH by shelling out to the rsync binary. rsync has no notion of deleting a single remote path
+// without a local counterpart, so deletions fall back to "ssh <host> rm -rf <path>".
+type rsyncBackend struct {
+	destination string
+	sshOptions  string
+}
+
+func (b *rsyncBackend) Handle(ctx context.Context, workPackage []workItem) error {
+	var toSync []string
+	var toDelete []string
+	for _, item := range workPackage {
+		if item.eventType == deleted {
+			toDelete = append(toDelete, item.path)
+		} else {
+			toSync = append(toSync, item.path)
+		}
+	}
+	if len(toSync) > 0 {
+		args := []string{"-a", "--relative"}
+		if b.sshOptions != "" {
+			args = append(args, "-e", "ssh "+b.sshOptions)
+		}
+		args = append(args, toSync...)
+		args = append(args, b.destination)
+		cmd := exec.Command("rsync", args...)
+		logger.Println("Start rsync", cmd)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("could not start rsync: %w", err)
+		}
+		if err := waitOrStop(ctx, cmd, syscall.SIGTERM, 100*time.Millisecond); err != nil {
+			return fmt.Errorf("rsync error: %w", err)
+		}
+	}
+	host, remoteRoot, found := strings.Cut(b.destination, ":")
+	for _, path := range toDelete {
+		if !found {
+			logger.Printf("rsyncBackend: Destination %v has no host part; cannot delete %v remotely", b.destination, path)
+			continue
+		}
+		remotePath := filepath.Join(remoteRoot, path)
+		sshArgs := []string{host, "rm", "-rf", remotePath}
+		if b.sshOptions != "" {
+			sshArgs = append(strings.Fields(b.sshOptions), sshArgs...)
+		}
+		cmd := exec.Command("ssh", sshArgs...)
+		logger.Println("Start ssh", cmd)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("could not start ssh: %w", err)
+		}
+		if err := waitOrStop(ctx, cmd, syscall.SIGTERM, 100*time.Millisecond); err != nil {
+			return fmt.Errorf("ssh error: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyBackend mirrors a watched dir into a local destination directory, preserving the paths'
+// position relative to root.
+type copyBackend struct {
+	root        string
+	destination string
+}
+
+func (b *copyBackend) Handle(ctx context.Context, workPackage []workItem) error {
+	for _, item := range workPackage {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(b.root, item.path)
+		if err != nil {
+			relPath = filepath.Base(item.path)
+		}
+		destPath := filepath.Join(b.destination, relPath)
+		if item.eventType == deleted {
+			if err := os.RemoveAll(destPath); err != nil {
+				return fmt.Errorf("could not remove %v: %w", destPath, err)
+			}
+			continue
+		}
+		if item.nodeType == directory {
+			if err := os.MkdirAll(destPath, 0o755); err != nil {
+				return fmt.Errorf("could not create directory %v: %w", destPath, err)
+			}
+			continue
+		}
+		if err := copyFile(item.path, destPath); err != nil {
+			return fmt.Errorf("could not copy %v to %v: %w", item.path, destPath, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// webhookBackend POSTs a work package as a JSON array to url.
+type webhookBackend struct {
+	url    string
+	client *http.Client
+}
+
+var nodeTypeNames = map[int]string{unknown: "unknown", directory: "directory", file: "file"}
+var eventTypeNames = map[int]string{modified: "modified", deleted: "deleted"}
+
+type workItemPayload struct {
+	Path      string `json:"path"`
+	NodeType  string `json:"node_type"`
+	EventType string `json:"event_type"`
+}
+
+func (b *webhookBackend) Handle(ctx context.Context, workPackage []workItem) error {
+	payload := make([]workItemPayload, len(workPackage))
+	for i, item := range workPackage {
+		payload[i] = workItemPayload{Path: item.path, NodeType: nodeTypeNames[item.nodeType], EventType: eventTypeNames[item.eventType]}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not marshal work package: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := b.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %v", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// appLogger is a small leveled logger with an optional structured-JSON output mode, so the tool
+// can be run as a systemd/container service and have its output ingested by a log pipeline. It
+// exposes the same Println/Printf/Panic/Panicf surface as the log.Logger it replaces.
+type appLogger struct {
+	out      io.Writer
+	jsonMode bool
+}
+
+func newLogger(out io.Writer, jsonMode bool) *appLogger {
+	return &appLogger{out: out, jsonMode: jsonMode}
+}
+
+type logLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func (l *appLogger) write(logLevel, message string) {
+	message = strings.TrimSuffix(message, "\n")
+	if !l.jsonMode {
+		fmt.Fprintln(l.out, message)
+		return
+	}
+	line, err := json.Marshal(logLine{Time: time.Now().Format(time.RFC3339Nano), Level: logLevel, Message: message})
+	if err != nil {
+		fmt.Fprintln(l.out, message)
+		return
+	}
+	fmt.Fprintln(l.out, string(line))
+}
+
+func (l *appLogger) Println(args ...any) {
+	l.write("info", fmt.Sprintln(args...))
+}
+
+func (l *appLogger) Printf(format string, args ...any) {
+	l.write("info", fmt.Sprintf(format, args...))
+}
+
+func (l *appLogger) Panic(args ...any) {
+	message := fmt.Sprint(args...)
+	l.write("fatal", message)
+	panic(message)
+}
+
+func (l *appLogger) Panicf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	l.write("fatal", message)
+	panic(message)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	eventsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_events_received_total",
+		Help: "Filesystem events received from the watcher backend, per watched root.",
+	}, []string{"root"})
+
+	eventsCoalescedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_events_coalesced_total",
+		Help: "Events folded into an already-agglomerating work item instead of appended, per watched root.",
+	}, []string{"root"})
+
+	workPackagesDispatchedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_work_packages_dispatched_total",
+		Help: "Work packages handed to a backend, per watched root.",
+	}, []string{"root"})
+
+	commandDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "watchdog_command_duration_seconds",
+		Help: "Time a backend took to handle one work package, per watched root.",
+	}, []string{"root"})
+
+	commandsFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "watchdog_commands_failed_total",
+		Help: "Work packages whose backend returned an error, per watched root.",
+	}, []string{"root"})
+
+	watchDescriptors = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_watch_descriptors",
+		Help: "Directories currently carrying an explicit watch, per watched root.",
+	}, []string{"root"})
+
+	lastSuccessfulSyncTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watchdog_last_successful_sync_timestamp_seconds",
+		Help: "Unix timestamp of the last work package a backend handled without error, per watched root.",
+	}, []string{"root"})
+)
+
+// deleteMetricsForRoot removes every per-root label series for a watched dir. It is called when a
+// watched dir is torn down for good (as opposed to merely reloaded), so that repeated add/remove
+// cycles via SIGHUP reload don't grow label cardinality without bound.
+func deleteMetricsForRoot(root string) {
+	eventsReceivedTotal.DeleteLabelValues(root)
+	eventsCoalescedTotal.DeleteLabelValues(root)
+	workPackagesDispatchedTotal.DeleteLabelValues(root)
+	commandDurationSeconds.DeleteLabelValues(root)
+	commandsFailedTotal.DeleteLabelValues(root)
+	watchDescriptors.DeleteLabelValues(root)
+	lastSuccessfulSyncTimestamp.DeleteLabelValues(root)
+}
+
+// serveMetrics starts the embedded Prometheus /metrics endpoint on address and blocks until ctx is
+// done, or until the server fails (e.g. the address cannot be bound), which it logs. If address is
+// empty, it does nothing.
+func serveMetrics(ctx context.Context, address string) {
+	if address == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Printf("serveMetrics: Could not shut down metrics server: %v", err)
+		}
+	}()
+	logger.Printf("serveMetrics: Listening on %v", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Printf("serveMetrics: Metrics server error: %v", err)
+	}
+}
@@ -2,24 +2,23 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
-	"log"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v2"
 )
 
-var logger *log.Logger
+var logger *appLogger
 
 func isExcluded(path string, excludeRegexps []*regexp.Regexp) bool {
 	for _, regexp := range excludeRegexps {
@@ -30,22 +29,131 @@ func isExcluded(path string, excludeRegexps []*regexp.Regexp) bool {
 	return false
 }
 
-type watchedDir struct {
-	root              string
+// includePattern is a single compiled `includes` glob. literalPrefix is the run of whole path
+// components of the original pattern before its first wildcard (e.g. "logs/" for "logs/*.log",
+// "" for "log*/x"); it is used to decide whether a directory could possibly contain a match
+// without having to walk into it.
+type includePattern struct {
+	regexp        *regexp.Regexp
+	literalPrefix string
+}
+
+// compileInclude turns a glob pattern such as "**/*.go" or "logs/*.log" into an includePattern.
+// "*" matches within a path component, "**/" matches zero or more whole path components (so
+// "**/*.go" also matches a "main.go" sitting directly in the watched root), and "?" matches a
+// single non-separator rune.
+func compileInclude(pattern string) (includePattern, error) {
+	var expression strings.Builder
+	expression.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				if i+2 < len(runes) && runes[i+2] == '/' {
+					expression.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					expression.WriteString(".*")
+					i++
+				}
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '.', '+', '(', ')', '|', '^', '$', '{', '}', '\\':
+			expression.WriteString("\\" + string(runes[i]))
+		default:
+			expression.WriteRune(runes[i])
+		}
+	}
+	expression.WriteString("$")
+	compiled, err := regexp.Compile(expression.String())
+	if err != nil {
+		return includePattern{}, err
+	}
+	literalPrefix := pattern
+	if idx := strings.IndexAny(pattern, "*?"); idx >= 0 {
+		literalPrefix = pattern[:strings.LastIndex(pattern[:idx], "/")+1]
+	}
+	return includePattern{regexp: compiled, literalPrefix: literalPrefix}, nil
+}
+
+// matchesIncludes reports whether relPath is selected by includes. An empty includes list means
+// everything is selected.
+func matchesIncludes(relPath string, includes []includePattern) bool {
+	if len(includes) == 0 {
+		return true
+	}
+	for _, include := range includes {
+		if include.regexp.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathHasPrefix reports whether prefix is a path-component prefix of path, unlike
+// strings.HasPrefix which would also accept a same-named sibling (e.g. "log" is not a
+// path-component prefix of "logs/", even though it is a string prefix of it).
+func pathHasPrefix(path, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// couldContainMatches reports whether the directory at relPath could plausibly contain a file
+// selected by includes, without knowing its contents. It is used to decide whether a directory
+// is worth recursing into and adding a watch for.
+func couldContainMatches(relPath string, includes []includePattern) bool {
+	if len(includes) == 0 || relPath == "." {
+		return true
+	}
+	for _, include := range includes {
+		if pathHasPrefix(include.literalPrefix, relPath) || pathHasPrefix(relPath, include.literalPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedDirConfig holds the settings of a watched dir that can be changed by a SIGHUP reload
+// without tearing down its goroutines or its watch: agglomeration time, excludes and includes.
+type watchedDirConfig struct {
 	agglomerationTime time.Duration
-	workItems         chan workItem
-	workPackages      chan []workItem
-	watcher           *fsnotify.Watcher
 	excludeRegexps    []*regexp.Regexp
+	includes          []includePattern
+}
+
+type watchedDir struct {
+	root            string
+	refreshInterval time.Duration
+	workItems       chan workItem
+	workPackages    chan []workItem
+	watcher         fsWatcher
+	backend         Backend
+	config          *atomic.Pointer[watchedDirConfig]
 }
 
-func readConfiguration() (watchedDirs []watchedDir, currentDir string) {
+func readConfiguration() (watchedDirs []watchedDir, currentDir string, watcherKind string,
+	metricsAddress string, jsonLog bool) {
 	var configuration struct {
-		CurrentDir  string `yaml:"current dir"`
-		WatchedDirs []struct {
+		CurrentDir     string `yaml:"current dir"`
+		Watcher        string `yaml:"watcher"`
+		MetricsAddress string `yaml:"metrics address"`
+		LogFormat      string `yaml:"log format"`
+		WatchedDirs    []struct {
 			Root              string
 			AgglomerationTime string `yaml:"agglomeration ms"`
+			RefreshEvery      string `yaml:"refresh every"`
 			Excludes          []string
+			Includes          []string
+			Action            actionConfig
 		} `yaml:"watched dirs"`
 	}
 	configurationFilePath := filepath.Join(os.Args[1], "configuration.yaml")
@@ -62,29 +170,57 @@ func readConfiguration() (watchedDirs []watchedDir, currentDir string) {
 			workItems:    make(chan workItem),
 			workPackages: make(chan []workItem),
 		}
+		dirConfig := &watchedDirConfig{}
 		if configItem.AgglomerationTime == "" {
-			watchedDir.agglomerationTime = 10 * time.Millisecond
+			dirConfig.agglomerationTime = 10 * time.Millisecond
 		} else if ms, err := strconv.Atoi(configItem.AgglomerationTime); err != nil {
 			logger.Panicf("Invalid configuration file %v: Agglomeration time %v is not an integer",
 				configurationFilePath, configItem.AgglomerationTime)
 		} else {
-			watchedDir.agglomerationTime = time.Duration(ms) * time.Millisecond
+			dirConfig.agglomerationTime = time.Duration(ms) * time.Millisecond
+		}
+		if configItem.RefreshEvery != "" {
+			if refreshInterval, err := time.ParseDuration(configItem.RefreshEvery); err != nil {
+				logger.Panicf("Invalid configuration file %v: Refresh interval %v is not a valid duration",
+					configurationFilePath, configItem.RefreshEvery)
+			} else {
+				watchedDir.refreshInterval = refreshInterval
+			}
 		}
 		for _, pattern := range configItem.Excludes {
 			if excludeRegexp, err := regexp.Compile(pattern); err != nil {
 				logger.Panicf("Invalid configuration file %v: Regexp %v is invalid",
 					configurationFilePath, pattern)
 			} else {
-				watchedDir.excludeRegexps = append(watchedDir.excludeRegexps, excludeRegexp)
+				dirConfig.excludeRegexps = append(dirConfig.excludeRegexps, excludeRegexp)
+			}
+		}
+		for _, pattern := range configItem.Includes {
+			if include, err := compileInclude(pattern); err != nil {
+				logger.Panicf("Invalid configuration file %v: Glob pattern %v is invalid",
+					configurationFilePath, pattern)
+			} else {
+				dirConfig.includes = append(dirConfig.includes, include)
 			}
 		}
+		watchedDir.config = new(atomic.Pointer[watchedDirConfig])
+		watchedDir.config.Store(dirConfig)
+		watchedDir.backend = newBackend(configurationFilePath, configItem.Root, configItem.Action, os.Args[1])
 		watchedDirs = append(watchedDirs, watchedDir)
 	}
-	return watchedDirs, configuration.CurrentDir
+	switch configuration.LogFormat {
+	case "", "text":
+		jsonLog = false
+	case "json":
+		jsonLog = true
+	default:
+		logger.Panicf("Invalid configuration file %v: Unknown log format %v", configurationFilePath, configuration.LogFormat)
+	}
+	return watchedDirs, configuration.CurrentDir, selectWatcherKind(configuration.Watcher), configuration.MetricsAddress, jsonLog
 }
 
 func init() {
-	logger = log.New(os.Stderr, "", 0)
+	logger = newLogger(os.Stderr, false)
 }
 
 const (
@@ -134,55 +270,199 @@ func longestPrefix(paths []string) string {
 	return result
 }
 
-func addWatches(watcher *fsnotify.Watcher, root string) {
+// addWatches walks root and adds a watch for every directory in it, returning the paths it
+// watched. base is the overall watched-dir root that includes patterns are relative to; root may
+// be a subtree of base, e.g. a directory that was just created. Directories that, per includes,
+// cannot plausibly contain a matching file are neither watched nor recursed into. It is a no-op
+// for a Recursive watcher, whose Start call already covers the whole subtree natively.
+func addWatches(watcher fsWatcher, base string, root string, includes []includePattern) (added []string) {
+	if watcher.Recursive() {
+		return nil
+	}
 	if err := filepath.WalkDir(root,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
 			if d.IsDir() {
+				relPath, relErr := filepath.Rel(base, path)
+				if relErr != nil {
+					relPath = path
+				}
+				relPath = filepath.ToSlash(relPath)
+				if !couldContainMatches(relPath, includes) {
+					return filepath.SkipDir
+				}
 				if err := watcher.Add(path); err != nil {
 					logger.Printf("Could not add watch of directory %v: %v; ignoring", path, err)
+				} else {
+					added = append(added, path)
 				}
 			}
 			return nil
 		}); err != nil {
 		logger.Printf("Could not walk through directory %v: %v; ignoring", root, err)
 	}
+	return added
 }
 
-func eventsWatcher(ctx context.Context,
-	watcher *fsnotify.Watcher, workItems chan<- workItem, excludeRegexps []*regexp.Regexp) {
+// reconciliationScan periodically walks root and synthesizes workItems for paths whose
+// modification time has changed, or which have disappeared, since the previous scan. It is a
+// safety net against fsnotify events that are missed under load, events for files that already
+// existed before their watch was registered, or subdirectories on filesystems without native
+// recursive watching. If refreshInterval is zero, reconciliationScan does nothing.
+func reconciliationScan(ctx context.Context, root string, refreshInterval time.Duration,
+	workItems chan<- workItem, config *atomic.Pointer[watchedDirConfig]) {
 	defer ctx.Value(wgKey).(*sync.WaitGroup).Done()
+	if refreshInterval == 0 {
+		return
+	}
+	knownMtimes := make(map[string]time.Time)
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case event := <-watcher.Events:
-			if isExcluded(event.Name, excludeRegexps) {
-				logger.Println("eventsWatcher: Ignored", event.Name)
+		case <-ticker.C:
+			cfg := config.Load()
+			seen := make(map[string]bool, len(knownMtimes))
+			if err := filepath.WalkDir(root,
+				func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					if isExcluded(path, cfg.excludeRegexps) {
+						if d.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					relPath, relErr := filepath.Rel(root, path)
+					if relErr != nil {
+						relPath = path
+					}
+					relPath = filepath.ToSlash(relPath)
+					if d.IsDir() {
+						if !couldContainMatches(relPath, cfg.includes) {
+							return filepath.SkipDir
+						}
+					} else if !matchesIncludes(relPath, cfg.includes) {
+						return nil
+					}
+					info, err := d.Info()
+					if err != nil {
+						logger.Printf("reconciliationScan: Could not stat %v: %v; ignoring", path, err)
+						return nil
+					}
+					seen[path] = true
+					if mtime := info.ModTime(); !mtime.Equal(knownMtimes[path]) {
+						knownMtimes[path] = mtime
+						nodeType := file
+						if d.IsDir() {
+							nodeType = directory
+						}
+						select {
+						case workItems <- workItem{path: path, nodeType: nodeType, eventType: modified}:
+						case <-ctx.Done():
+							return ctx.Err()
+						}
+					}
+					return nil
+				}); err != nil && ctx.Err() != nil {
+				return
+			} else if err != nil {
+				logger.Printf("reconciliationScan: Could not walk through directory %v: %v; ignoring", root, err)
+			}
+			for path := range knownMtimes {
+				if !seen[path] {
+					delete(knownMtimes, path)
+					select {
+					case workItems <- workItem{path: path, eventType: deleted}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eventsWatcher consumes watcher events for the watched dir rooted at base. On a non-Recursive
+// watcher it also keeps track of which directories currently carry a watch so that, when a
+// directory disappears, it and its descendants' watches can be explicitly removed instead of
+// leaking watch descriptors; a Recursive watcher manages that itself.
+func eventsWatcher(ctx context.Context, watcher fsWatcher, base string, initialWatches []string,
+	workItems chan<- workItem, config *atomic.Pointer[watchedDirConfig]) {
+	defer ctx.Value(wgKey).(*sync.WaitGroup).Done()
+	recursive := watcher.Recursive()
+	watchedDirs := make(map[string]bool, len(initialWatches))
+	for _, path := range initialWatches {
+		watchedDirs[path] = true
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				return
+			}
+			eventsReceivedTotal.WithLabelValues(base).Inc()
+			cfg := config.Load()
+			if isExcluded(event.path, cfg.excludeRegexps) {
+				logger.Println("eventsWatcher: Ignored", event.path)
 				break
 			}
-			newWorkItem := workItem{path: event.Name}
-			if event.Op&fsnotify.Create == fsnotify.Create ||
-				event.Op&fsnotify.Write == fsnotify.Write ||
-				event.Op&fsnotify.Chmod == fsnotify.Chmod {
+			relPath, relErr := filepath.Rel(base, event.path)
+			if relErr != nil {
+				relPath = event.path
+			}
+			relPath = filepath.ToSlash(relPath)
+			newWorkItem := workItem{path: event.path}
+			if event.create || event.write {
 				newWorkItem.eventType = modified
-				info, err := os.Stat(event.Name)
+				info, err := os.Stat(event.path)
 				if err != nil {
-					logger.Printf("eventsWatcher: Error when trying to stat %v: %v", event.Name, err)
+					logger.Printf("eventsWatcher: Error when trying to stat %v: %v", event.path, err)
 					newWorkItem.nodeType = unknown
 				} else if info.IsDir() {
 					newWorkItem.nodeType = directory
-					if event.Op&fsnotify.Create == fsnotify.Create {
-						addWatches(watcher, event.Name)
+					if !recursive && event.create && couldContainMatches(relPath, cfg.includes) {
+						for _, path := range addWatches(watcher, base, event.path, cfg.includes) {
+							watchedDirs[path] = true
+						}
+						watchDescriptors.WithLabelValues(base).Set(float64(len(watchedDirs)))
 					}
 				} else {
+					if !matchesIncludes(relPath, cfg.includes) {
+						logger.Println("eventsWatcher: Ignored (not included)", event.path)
+						break
+					}
 					newWorkItem.nodeType = file
 				}
 			} else {
 				newWorkItem.eventType = deleted
+				if !recursive && watchedDirs[event.path] {
+					removeWatch(watcher, event.path)
+					delete(watchedDirs, event.path)
+					prefix := event.path + string(filepath.Separator)
+					for path := range watchedDirs {
+						if strings.HasPrefix(path, prefix) {
+							removeWatch(watcher, path)
+							delete(watchedDirs, path)
+						}
+					}
+					watchDescriptors.WithLabelValues(base).Set(float64(len(watchedDirs)))
+				}
+			}
+			select {
+			case workItems <- newWorkItem:
+			case <-ctx.Done():
+				return
+			}
+		case err, ok := <-watcher.Errors():
+			if !ok {
+				return
 			}
-			workItems <- newWorkItem
-		case err := <-watcher.Errors:
 			logger.Printf("eventsWatcher: Error %v (ignoring)", err)
 		case <-ctx.Done():
 			return
@@ -190,17 +470,25 @@ func eventsWatcher(ctx context.Context,
 	}
 }
 
-func appendWorkItem(workItems []workItem, workItem workItem) []workItem {
+func removeWatch(watcher fsWatcher, path string) {
+	if err := watcher.Remove(path); err != nil {
+		logger.Printf("eventsWatcher: Could not remove watch of %v: %v; ignoring", path, err)
+	}
+}
+
+func appendWorkItem(root string, workItems []workItem, workItem workItem) []workItem {
 	for i := range workItems {
 		i = len(workItems) - 1 - i
 		item := workItems[i]
 		if item == workItem {
 			logger.Println("appendWorkItem: Ignored duplicate")
+			eventsCoalescedTotal.WithLabelValues(root).Inc()
 			return workItems
 		}
 		if item.path == workItem.path && workItem.eventType == deleted && item.eventType == modified {
 			logger.Println("appendWorkItem: \"modified\" replaced with \"deleted\"")
 			workItems[i] = workItem
+			eventsCoalescedTotal.WithLabelValues(root).Inc()
 			return workItems
 		}
 	}
@@ -208,8 +496,8 @@ func appendWorkItem(workItems []workItem, workItem workItem) []workItem {
 	return append(workItems, workItem)
 }
 
-func workMarshaller(ctx context.Context,
-	workItems <-chan workItem, workPackages chan<- []workItem, agglomerationTime time.Duration) {
+func workMarshaller(ctx context.Context, root string,
+	workItems <-chan workItem, workPackages chan<- []workItem, config *atomic.Pointer[watchedDirConfig]) {
 	defer ctx.Value(wgKey).(*sync.WaitGroup).Done()
 	defer close(workPackages)
 	currentWorkItems := make([]workItem, 0, 100)
@@ -221,8 +509,8 @@ func workMarshaller(ctx context.Context,
 				case workPackages <- currentWorkItems:
 					currentWorkItems = make([]workItem, 0, 100)
 				case singleWorkItem := <-workItems:
-					currentWorkItems = appendWorkItem(currentWorkItems, singleWorkItem)
-					timer = time.NewTimer(agglomerationTime)
+					currentWorkItems = appendWorkItem(root, currentWorkItems, singleWorkItem)
+					timer = time.NewTimer(config.Load().agglomerationTime)
 				case <-ctx.Done():
 					return
 				}
@@ -236,8 +524,8 @@ func workMarshaller(ctx context.Context,
 					default:
 					}
 				case singleWorkItem := <-workItems:
-					currentWorkItems = appendWorkItem(currentWorkItems, singleWorkItem)
-					timer = time.NewTimer(agglomerationTime)
+					currentWorkItems = appendWorkItem(root, currentWorkItems, singleWorkItem)
+					timer = time.NewTimer(config.Load().agglomerationTime)
 				case <-ctx.Done():
 					return
 				}
@@ -245,8 +533,8 @@ func workMarshaller(ctx context.Context,
 		} else {
 			select {
 			case singleWorkItem := <-workItems:
-				currentWorkItems = appendWorkItem(currentWorkItems, singleWorkItem)
-				timer = time.NewTimer(agglomerationTime)
+				currentWorkItems = appendWorkItem(root, currentWorkItems, singleWorkItem)
+				timer = time.NewTimer(config.Load().agglomerationTime)
 			case <-ctx.Done():
 				return
 			}
@@ -254,32 +542,18 @@ func workMarshaller(ctx context.Context,
 	}
 }
 
-func worker(ctx context.Context, workPackages <-chan []workItem) {
+func worker(ctx context.Context, root string, workPackages <-chan []workItem, backend Backend) {
 	defer ctx.Value(wgKey).(*sync.WaitGroup).Done()
-	scriptsDir := os.Args[1]
 	for workPackage := range workPackages {
-		var cmd *exec.Cmd
-		if len(workPackage) > 1 {
-			paths := make([]string, 0, len(workPackage))
-			for _, workItem := range workPackage {
-				paths = append(paths, workItem.path)
-			}
-			cmd = exec.Command(filepath.Join(scriptsDir, "bulk_sync"), longestPrefix(paths))
+		workPackagesDispatchedTotal.WithLabelValues(root).Inc()
+		start := time.Now()
+		err := backend.Handle(ctx, workPackage)
+		commandDurationSeconds.WithLabelValues(root).Observe(time.Since(start).Seconds())
+		if err != nil {
+			commandsFailedTotal.WithLabelValues(root).Inc()
+			logger.Println("Backend error:", err)
 		} else {
-			workItem := workPackage[0]
-			if workItem.eventType == deleted {
-				cmd = exec.Command(filepath.Join(scriptsDir, "delete"), workItem.path)
-			} else if workItem.nodeType == file {
-				cmd = exec.Command(filepath.Join(scriptsDir, "copy"), workItem.path)
-			} else {
-				cmd = exec.Command(filepath.Join(scriptsDir, "bulk_sync"), workItem.path)
-			}
-		}
-		logger.Println("Start external command", cmd)
-		if err := cmd.Start(); err != nil {
-			logger.Println("Could not start external command:", err)
-		} else if err := waitOrStop(ctx, cmd, syscall.SIGTERM, 100*time.Millisecond); err != nil {
-			logger.Println("External command error:", err)
+			lastSuccessfulSyncTimestamp.WithLabelValues(root).Set(float64(time.Now().Unix()))
 		}
 	}
 }
@@ -288,37 +562,147 @@ type key int
 
 const wgKey key = 0
 
+// runningDir is a watched dir's live goroutines plus the means to stop them independently of the
+// rest of the process, so that reloadConfiguration can tear down just the dirs that a SIGHUP
+// reload removes from the configuration file.
+type runningDir struct {
+	watchedDir watchedDir
+	cancel     context.CancelFunc
+	wg         *sync.WaitGroup
+}
+
+// startWatchedDir launches all goroutines for one watched dir under their own cancelable
+// sub-context of parentCtx, with their own wait group, so it can later be stopped on its own by
+// stopWatchedDir without affecting any other watched dir.
+func startWatchedDir(parentCtx context.Context, watcherKind string, watchedDir watchedDir) *runningDir {
+	ctx, cancel := context.WithCancel(parentCtx)
+	var wg sync.WaitGroup
+	ctx = context.WithValue(ctx, wgKey, &wg)
+
+	wg.Add(4)
+	go workMarshaller(ctx, watchedDir.root, watchedDir.workItems, watchedDir.workPackages, watchedDir.config)
+	go worker(ctx, watchedDir.root, watchedDir.workPackages, watchedDir.backend)
+	var err error
+	if watchedDir.watcher, err = newFsWatcher(watcherKind); err != nil {
+		logger.Panic("Could not create new empty watcher")
+	}
+	var initialWatches []string
+	if watchedDir.watcher.Recursive() {
+		if err := watchedDir.watcher.Start(watchedDir.root); err != nil {
+			logger.Panicf("Could not start recursive watch of %v: %v", watchedDir.root, err)
+		}
+	} else {
+		initialWatches = addWatches(watchedDir.watcher, watchedDir.root, watchedDir.root, watchedDir.config.Load().includes)
+	}
+	watchDescriptors.WithLabelValues(watchedDir.root).Set(float64(len(initialWatches)))
+	go eventsWatcher(ctx, watchedDir.watcher, watchedDir.root, initialWatches, watchedDir.workItems, watchedDir.config)
+	go reconciliationScan(ctx, watchedDir.root, watchedDir.refreshInterval, watchedDir.workItems, watchedDir.config)
+
+	return &runningDir{watchedDir: watchedDir, cancel: cancel, wg: &wg}
+}
+
+// stopWatchedDir cancels a running watched dir's sub-context, waits for its goroutines to exit and
+// closes its watcher.
+func stopWatchedDir(runningDir *runningDir) {
+	runningDir.cancel()
+	runningDir.wg.Wait()
+	if err := runningDir.watchedDir.watcher.Close(); err != nil {
+		logger.Printf("stopWatchedDir: Could not close watcher for %v: %v", runningDir.watchedDir.root, err)
+	}
+	deleteMetricsForRoot(runningDir.watchedDir.root)
+}
+
+// tryReadConfiguration wraps readConfiguration so that reloadConfiguration can validate the
+// configuration file without panicking the whole process: readConfiguration's own panics are only
+// appropriate at startup, when there is no previous, still-running configuration worth keeping.
+func tryReadConfiguration() (watchedDirs []watchedDir, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	watchedDirs, _, _, _, _ = readConfiguration()
+	return watchedDirs, nil
+}
+
+// tryStartWatchedDir wraps startWatchedDir so that reloadConfiguration can start a root newly
+// added by the configuration file without a single root's startup failure (e.g. watcher creation
+// failing under descriptor exhaustion) crashing the whole running process; startWatchedDir's own
+// panics are only appropriate at startup, when there is no previous configuration worth keeping.
+func tryStartWatchedDir(parentCtx context.Context, watcherKind string, watchedDir watchedDir) (started *runningDir, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	started = startWatchedDir(parentCtx, watcherKind, watchedDir)
+	return started, nil
+}
+
+// reloadConfiguration re-reads the configuration file in reaction to a SIGHUP, without restarting
+// the process. Watched dirs absent from the new file are stopped via stopWatchedDir; dirs newly
+// present are started via startWatchedDir; dirs present in both keep their goroutines and watch,
+// and just get their agglomeration time, excludes and includes swapped in place. refresh every,
+// currentDir, watcher, metrics address and log format are read once at startup and are not
+// reloadable; changing them in the configuration file requires a restart. If the new configuration
+// file is invalid, or a newly added root fails to start, the previous configuration keeps running
+// unchanged for the other roots.
+func reloadConfiguration(ctx context.Context, watcherKind string, running map[string]*runningDir) {
+	logger.Println("Received SIGHUP, reloading configuration.")
+	newWatchedDirs, err := tryReadConfiguration()
+	if err != nil {
+		logger.Printf("reloadConfiguration: Configuration file is invalid, keeping previous configuration: %v", err)
+		return
+	}
+	seen := make(map[string]bool, len(newWatchedDirs))
+	for _, watchedDir := range newWatchedDirs {
+		seen[watchedDir.root] = true
+		if runningDir, ok := running[watchedDir.root]; ok {
+			runningDir.watchedDir.config.Store(watchedDir.config.Load())
+		} else if started, err := tryStartWatchedDir(ctx, watcherKind, watchedDir); err != nil {
+			logger.Printf("reloadConfiguration: Could not start newly added watched dir %v, skipping: %v", watchedDir.root, err)
+		} else {
+			running[watchedDir.root] = started
+		}
+	}
+	for root, runningDir := range running {
+		if !seen[root] {
+			stopWatchedDir(runningDir)
+			delete(running, root)
+		}
+	}
+}
+
 func main() {
 	defer logger.Println("Exiting gracefully.")
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-	defer wg.Wait()
-	ctx = context.WithValue(ctx, wgKey, &wg)
-
-	go func() {
-		<-sigs
-		cancel()
-	}()
-
-	watchedDirs, currentDir := readConfiguration()
+	watchedDirs, currentDir, watcherKind, metricsAddress, jsonLog := readConfiguration()
+	logger = newLogger(os.Stderr, jsonLog)
 	if err := os.Chdir(currentDir); err != nil {
 		logger.Panicf("Could not set current working directory to %v", currentDir)
 	}
+	go serveMetrics(ctx, metricsAddress)
+
+	running := make(map[string]*runningDir, len(watchedDirs))
 	for _, watchedDir := range watchedDirs {
-		wg.Add(3)
-		go workMarshaller(ctx, watchedDir.workItems, watchedDir.workPackages, watchedDir.agglomerationTime)
-		go worker(ctx, watchedDir.workPackages)
-		var err error
-		if watchedDir.watcher, err = fsnotify.NewWatcher(); err != nil {
-			logger.Panic("Could not create new empty watcher")
+		running[watchedDir.root] = startWatchedDir(ctx, watcherKind, watchedDir)
+	}
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			reloadConfiguration(ctx, watcherKind, running)
+			continue
 		}
-		go eventsWatcher(ctx, watchedDir.watcher, watchedDir.workItems, watchedDir.excludeRegexps)
-		addWatches(watchedDir.watcher, watchedDir.root)
+		break
+	}
+	cancel()
+	for _, runningDir := range running {
+		runningDir.wg.Wait()
 	}
 }
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestCouldContainMatchesRespectsPathBoundaries(t *testing.T) {
+	include, err := compileInclude("logs/*.log")
+	if err != nil {
+		t.Fatalf("compileInclude: %v", err)
+	}
+	includes := []includePattern{include}
+	if couldContainMatches("log", includes) {
+		t.Error(`couldContainMatches("log", ["logs/*.log"]) = true, want false: "log" is not a path-component prefix of "logs/"`)
+	}
+	if !couldContainMatches("logs", includes) {
+		t.Error(`couldContainMatches("logs", ["logs/*.log"]) = false, want true`)
+	}
+}
+
+func TestMatchesIncludesDoubleStar(t *testing.T) {
+	cases := []struct {
+		pattern string
+		relPath string
+		want    bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "pkg/sub/main.go", true},
+		{"**/*.go", "main.txt", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/pkg/main.go", true},
+		{"src/**/*.go", "other/main.go", false},
+	}
+	for _, c := range cases {
+		include, err := compileInclude(c.pattern)
+		if err != nil {
+			t.Fatalf("compileInclude(%q): %v", c.pattern, err)
+		}
+		if got := matchesIncludes(c.relPath, []includePattern{include}); got != c.want {
+			t.Errorf("matchesIncludes(%q, [%q]) = %v, want %v", c.relPath, c.pattern, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rjeczalik/notify"
+)
+
+// fsEvent is the backend-agnostic shape of a single filesystem change notification.
+type fsEvent struct {
+	path   string
+	create bool
+	write  bool
+	remove bool
+	rename bool
+}
+
+// fsWatcher abstracts over the two watcher backends so that eventsWatcher and addWatches don't
+// have to care which one is in use.
+type fsWatcher interface {
+	// Start begins watching root. Backends for which Recursive is true cover the whole subtree
+	// with this single call; on the others it does nothing, and every directory, root included,
+	// is watched through Add.
+	Start(root string) error
+	// Add explicitly starts watching a single directory. It is a no-op on a Recursive backend.
+	Add(path string) error
+	// Remove stops watching path. It is a no-op on a Recursive backend.
+	Remove(path string) error
+	// Recursive reports whether Start already covers the whole subtree natively, making the
+	// WalkDir-based per-directory Add/Remove dance in addWatches and eventsWatcher unnecessary.
+	Recursive() bool
+	Events() <-chan fsEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// selectWatcherKind resolves the configured `watcher` setting ("fsnotify", "notify" or
+// "auto"/"") to a concrete kind. "auto" picks "notify" on platforms where it watches recursively
+// through the native OS API (macOS, Windows) and "fsnotify" elsewhere.
+func selectWatcherKind(configured string) string {
+	switch configured {
+	case "fsnotify", "notify":
+		return configured
+	case "", "auto":
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			return "notify"
+		}
+		return "fsnotify"
+	default:
+		logger.Panicf("Unknown watcher kind %v", configured)
+		return ""
+	}
+}
+
+func newFsWatcher(kind string) (fsWatcher, error) {
+	if selectWatcherKind(kind) == "notify" {
+		return newNotifyWatcher(), nil
+	}
+	return newFsnotifyWatcher()
+}
+
+// fsnotifyWatcher is the original, non-recursive backend: every directory must be watched
+// individually, which is what addWatches' WalkDir does.
+type fsnotifyWatcher struct {
+	watcher *fsnotify.Watcher
+	events  chan fsEvent
+	errors  chan error
+}
+
+func newFsnotifyWatcher() (*fsnotifyWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fsnotifyWatcher{
+		watcher: watcher,
+		events:  make(chan fsEvent),
+		errors:  make(chan error),
+	}
+	go w.relay()
+	return w, nil
+}
+
+func (w *fsnotifyWatcher) relay() {
+	defer close(w.events)
+	defer close(w.errors)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.events <- fsEvent{
+				path:   event.Name,
+				create: event.Op&fsnotify.Create == fsnotify.Create,
+				write:  event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Chmod == fsnotify.Chmod,
+				remove: event.Op&fsnotify.Remove == fsnotify.Remove,
+				rename: event.Op&fsnotify.Rename == fsnotify.Rename,
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.errors <- err
+		}
+	}
+}
+
+func (w *fsnotifyWatcher) Start(root string) error  { return nil }
+func (w *fsnotifyWatcher) Add(path string) error    { return w.watcher.Add(path) }
+func (w *fsnotifyWatcher) Remove(path string) error { return w.watcher.Remove(path) }
+func (w *fsnotifyWatcher) Recursive() bool          { return false }
+func (w *fsnotifyWatcher) Events() <-chan fsEvent   { return w.events }
+func (w *fsnotifyWatcher) Errors() <-chan error     { return w.errors }
+func (w *fsnotifyWatcher) Close() error             { return w.watcher.Close() }
+
+// notifyWatcher is built on github.com/rjeczalik/notify, which watches a whole subtree in one
+// call via its "path/..." syntax, using FSEvents on macOS and ReadDirectoryChangesW on Windows.
+// This avoids the O(n) WalkDir + per-directory Add cost that addWatches otherwise pays on
+// startup and after every directory Create.
+type notifyWatcher struct {
+	channel chan notify.EventInfo
+	events  chan fsEvent
+}
+
+func newNotifyWatcher() *notifyWatcher {
+	w := &notifyWatcher{
+		channel: make(chan notify.EventInfo, 100),
+		events:  make(chan fsEvent),
+	}
+	go w.relay()
+	return w
+}
+
+func (w *notifyWatcher) relay() {
+	defer close(w.events)
+	for event := range w.channel {
+		eventType := event.Event()
+		w.events <- fsEvent{
+			path:   event.Path(),
+			create: eventType == notify.Create,
+			write:  eventType == notify.Write,
+			remove: eventType == notify.Remove,
+			rename: eventType == notify.Rename,
+		}
+	}
+}
+
+func (w *notifyWatcher) Start(root string) error {
+	return notify.Watch(filepath.Join(root, "..."), w.channel, notify.All)
+}
+func (w *notifyWatcher) Add(path string) error    { return nil }
+func (w *notifyWatcher) Remove(path string) error { return nil }
+func (w *notifyWatcher) Recursive() bool          { return true }
+func (w *notifyWatcher) Events() <-chan fsEvent   { return w.events }
+func (w *notifyWatcher) Errors() <-chan error     { return nil }
+func (w *notifyWatcher) Close() error {
+	notify.Stop(w.channel)
+	close(w.channel)
+	return nil
+}